@@ -0,0 +1,160 @@
+// Copyright © 2017 Aqua Security Software Ltd. <info@aquasec.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build e2e
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	e2eKindVersion    = "v0.11.1"
+	e2eKubectlVersion = "v1.21.2"
+	e2eClusterName    = "kube-bench-e2e"
+)
+
+// dockerExecTransport runs commands inside a docker container via
+// `docker exec`, used to reach a kind node's process list without
+// hostPID.
+type dockerExecTransport struct {
+	container string
+}
+
+func (d dockerExecTransport) Output(name string, args ...string) ([]byte, error) {
+	return exec.Command("docker", append([]string{"exec", d.container, name}, args...)...).Output()
+}
+
+// TestE2ECheckPipeline spins up a kind cluster and runs this package's
+// check pipeline against it: version discovery and comparison
+// (getKubeVersion/checkKubeVersion), binary discovery (getBinaries), and
+// config file discovery (getConfigFiles). This package doesn't ship a
+// control/report runner to evaluate CIS controls against, so "pass/fail"
+// here means checkKubeVersion's own version-compatibility verdict rather
+// than a CIS control result; that's the nearest existing pass/fail
+// primitive this pipeline has to assert against a real cluster instead
+// of the `kubeoutput` fixture the rest of this package's tests use. It
+// downloads kind and kubectl on demand and tears the cluster down
+// afterwards, unless KUBE_BENCH_E2E_KEEP is set.
+func TestE2ECheckPipeline(t *testing.T) {
+	e2eEnsureToolchain(t)
+	e2eCreateCluster(t)
+	if os.Getenv("KUBE_BENCH_E2E_KEEP") == "" {
+		t.Cleanup(func() { e2eDeleteCluster(t) })
+	}
+
+	tgt := target{Context: "kind-" + e2eClusterName}
+
+	ver := getKubeVersion(tgt)
+	if ver == nil || ver.Server == "" {
+		t.Fatalf("expected a server version from the kind cluster, got %+v", ver)
+	}
+
+	parts := strings.SplitN(ver.Server, ".", 2)
+	if len(parts) != 2 {
+		t.Fatalf("expected a major.minor server version, got %q", ver.Server)
+	}
+	major, minor := parts[0], parts[1]
+
+	if _, mismatch := checkKubeVersion(tgt, major, minor); mismatch != "" {
+		t.Fatalf("expected checkKubeVersion to pass against the version it just reported (%s.%s), got %q", major, minor, mismatch)
+	}
+	if _, mismatch := checkKubeVersion(tgt, major, minor+"0"); mismatch == "" {
+		t.Fatalf("expected checkKubeVersion to fail against a deliberately wrong minor version")
+	}
+
+	nodeTgt := target{
+		Context: tgt.Context,
+		Remote:  dockerExecTransport{container: e2eClusterName + "-control-plane"},
+	}
+
+	binaries := getBinaries(nodeTgt, e2eCheckConfig())
+	// getBinaries falls back to the bare component name when no locator
+	// finds a match, so a resolved path that differs from "apiserver"
+	// is what tells us discovery actually succeeded.
+	apiserver, ok := binaries["apiserver"]
+	if !ok || apiserver.Path == "apiserver" {
+		t.Fatalf("expected to find the apiserver binary on the control-plane node, got %v", binaries)
+	}
+
+	confs := getConfigFiles(nodeTgt, e2eCheckConfig())
+	if confs["apiserver"] == "" || confs["apiserver"] == "apiserver" {
+		t.Fatalf("expected to find the apiserver manifest on the control-plane node, got %v", confs)
+	}
+}
+
+// e2eCheckConfig returns the component/bins/confs configuration the e2e
+// suite checks for on a kind control-plane node.
+func e2eCheckConfig() *viper.Viper {
+	v := viper.New()
+	v.Set("components", []string{"apiserver", "etcd"})
+	v.Set("apiserver", map[string]interface{}{
+		"bins":  []string{"kube-apiserver"},
+		"confs": []string{"/etc/kubernetes/manifests/kube-apiserver.yaml"},
+	})
+	v.Set("etcd", map[string]interface{}{"bins": []string{"etcd"}})
+	return v
+}
+
+// e2eEnsureToolchain downloads kind and kubectl into a temporary bin
+// directory and prepends it to PATH, unless they're already installed.
+func e2eEnsureToolchain(t *testing.T) {
+	bin := t.TempDir()
+	downloaded := false
+
+	if _, err := exec.LookPath("kind"); err != nil {
+		e2eDownload(t, bin, "kind", fmt.Sprintf("https://kind.sigs.k8s.io/dl/%s/kind-%s-amd64", e2eKindVersion, runtime.GOOS))
+		downloaded = true
+	}
+
+	if _, err := exec.LookPath("kubectl"); err != nil {
+		e2eDownload(t, bin, "kubectl", fmt.Sprintf("https://dl.k8s.io/release/%s/bin/%s/amd64/kubectl", e2eKubectlVersion, runtime.GOOS))
+		downloaded = true
+	}
+
+	if downloaded {
+		os.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	}
+}
+
+func e2eDownload(t *testing.T, dir string, name string, url string) {
+	dest := filepath.Join(dir, name)
+	if out, err := exec.Command("curl", "-sSLo", dest, url).CombinedOutput(); err != nil {
+		t.Fatalf("failed to download %s: %v\n%s", name, err, out)
+	}
+	if err := os.Chmod(dest, 0755); err != nil {
+		t.Fatalf("failed to chmod %s: %v", name, err)
+	}
+}
+
+func e2eCreateCluster(t *testing.T) {
+	if out, err := exec.Command("kind", "create", "cluster", "--name", e2eClusterName, "--wait", "60s").CombinedOutput(); err != nil {
+		t.Fatalf("failed to create kind cluster: %v\n%s", err, out)
+	}
+}
+
+func e2eDeleteCluster(t *testing.T) {
+	if out, err := exec.Command("kind", "delete", "cluster", "--name", e2eClusterName).CombinedOutput(); err != nil {
+		t.Logf("failed to delete kind cluster: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright © 2017 Aqua Security Software Ltd. <info@aquasec.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	sweepContexts string
+	sweepWorkers  int
+	sweepJUnit    string
+	sweepExpMajor string
+	sweepExpMinor string
+)
+
+// sweepCmd runs the check pipeline against every context in the
+// kubeconfig, or an explicit --contexts list, and aggregates the
+// per-cluster results into a single report.
+var sweepCmd = &cobra.Command{
+	Use:   "sweep",
+	Short: "Run checks across every context in the kubeconfig",
+	Run: func(cmd *cobra.Command, args []string) {
+		if (sweepExpMajor == "") != (sweepExpMinor == "") {
+			fmt.Fprintln(os.Stderr, "--expected-major and --expected-minor must be set together")
+			os.Exit(1)
+		}
+
+		targets, err := sweepTargets(sweepContexts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		results := runSweep(targets, sweepWorkers, sweepExpMajor, sweepExpMinor, viper.GetViper())
+
+		if err := writeSweepReport(results, sweepJUnit); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	sweepCmd.Flags().StringVar(&sweepContexts, "contexts", "", "comma-separated list of kubeconfig contexts to sweep (default: all contexts in the kubeconfig)")
+	sweepCmd.Flags().IntVar(&sweepWorkers, "workers", 4, "number of contexts to check concurrently")
+	sweepCmd.Flags().StringVar(&sweepJUnit, "junit-output", "", "file to write a combined JUnit report to, in addition to the JSON report on stdout")
+	sweepCmd.Flags().StringVar(&sweepExpMajor, "expected-major", "", "expected server major version; if set with --expected-minor, each target's version is checked against it")
+	sweepCmd.Flags().StringVar(&sweepExpMinor, "expected-minor", "", "expected server minor version; if set with --expected-major, each target's version is checked against it")
+	rootCmd.AddCommand(sweepCmd)
+}
+
+// sweepResult is the outcome of running the check pipeline against a
+// single target.
+type sweepResult struct {
+	Context      string                `json:"context"`
+	KubeVersion  *version              `json:"kubeVersion,omitempty"`
+	VersionCheck string                `json:"versionCheck,omitempty"`
+	Binaries     map[string]binaryInfo `json:"binaries,omitempty"`
+	ConfigFiles  map[string]string     `json:"configFiles,omitempty"`
+	Error        string                `json:"error,omitempty"`
+}
+
+// sweepTargets resolves the set of targets a sweep should run against:
+// the explicit comma-separated contexts list if given, otherwise every
+// context in the kubeconfig.
+func sweepTargets(contexts string) ([]target, error) {
+	if contexts != "" {
+		var targets []target
+		for _, c := range strings.Split(contexts, ",") {
+			targets = append(targets, target{Context: strings.TrimSpace(c)})
+		}
+		return targets, nil
+	}
+	return kubeconfigContexts()
+}
+
+// kubeconfigContexts lists every context defined in the current
+// kubeconfig, shelling out to kubectl rather than parsing the kubeconfig
+// file directly.
+func kubeconfigContexts() ([]target, error) {
+	out, err := exec.Command("kubectl", "config", "get-contexts", "-o", "name").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list kubeconfig contexts: %v", err)
+	}
+
+	var targets []target
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line == "" {
+			continue
+		}
+		targets = append(targets, target{Context: line})
+	}
+	return targets, nil
+}
+
+// runSweep runs the check pipeline against each target using a worker
+// pool of the given size, and returns one result per target, in the
+// order the targets were given. If expMajor/expMinor are set, each
+// target's server version is additionally checked against them.
+func runSweep(targets []target, workers int, expMajor string, expMinor string, v *viper.Viper) []sweepResult {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]sweepResult, len(targets))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				results[idx] = runSweepTarget(targets[idx], expMajor, expMinor, v)
+			}
+		}()
+	}
+
+	for idx := range targets {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// runSweepTarget runs the check pipeline against a single target.
+func runSweepTarget(tgt target, expMajor string, expMinor string, v *viper.Viper) sweepResult {
+	result := sweepResult{Context: tgt.Context}
+
+	ver, mismatch := checkKubeVersion(tgt, expMajor, expMinor)
+	if ver == nil {
+		result.Error = fmt.Sprintf("failed to get kube version for context %q", tgt.Context)
+		return result
+	}
+
+	result.KubeVersion = ver
+	result.VersionCheck = mismatch
+	result.Binaries = getBinaries(tgt, v)
+	result.ConfigFiles = getConfigFiles(tgt, v)
+
+	return result
+}
+
+// writeSweepReport prints the combined results as JSON to stdout, and,
+// if junitPath is non-empty, also writes a combined JUnit report there.
+func writeSweepReport(results []sweepResult, junitPath string) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(results); err != nil {
+		return fmt.Errorf("failed to encode sweep report: %v", err)
+	}
+
+	if junitPath == "" {
+		return nil
+	}
+
+	out, err := xml.MarshalIndent(sweepJUnitSuite(results), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode JUnit report: %v", err)
+	}
+
+	return os.WriteFile(junitPath, out, 0644)
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name    string        `xml:"name,attr"`
+	Failure *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+// sweepJUnitSuite renders results as a single JUnit test suite, one test
+// case per context swept.
+func sweepJUnitSuite(results []sweepResult) junitTestSuite {
+	suite := junitTestSuite{Name: "kube-bench sweep", Tests: len(results)}
+	for _, r := range results {
+		tc := junitTestCase{Name: r.Context}
+		if msg := r.Error; msg != "" {
+			tc.Failure = &junitFailure{Message: msg}
+			suite.Failures++
+		} else if msg := r.VersionCheck; msg != "" {
+			tc.Failure = &junitFailure{Message: msg}
+			suite.Failures++
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	return suite
+}
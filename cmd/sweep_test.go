@@ -0,0 +1,116 @@
+// Copyright © 2017 Aqua Security Software Ltd. <info@aquasec.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// fakeRemote is a remoteExec that returns canned output per command name,
+// used to drive the sweep pipeline without shelling out to a real
+// cluster.
+type fakeRemote struct {
+	out map[string][]byte
+	err error
+}
+
+func (f fakeRemote) Output(name string, args ...string) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.out[name], nil
+}
+
+func TestSweepTargets(t *testing.T) {
+	targets, err := sweepTargets("foo, bar")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exp := []target{{Context: "foo"}, {Context: "bar"}}
+	if !reflect.DeepEqual(targets, exp) {
+		t.Fatalf("Got %v expected %v", targets, exp)
+	}
+}
+
+func TestRunSweepTarget(t *testing.T) {
+	kubeoutput := []byte(`Client Version: version.Info{Major:"1", Minor:"7"}
+Server Version: version.Info{Major:"1", Minor:"7"}`)
+
+	tgt := target{Context: "test", Remote: fakeRemote{out: map[string][]byte{"kubectl": kubeoutput, "ps": []byte("")}}}
+	v := viper.New()
+	v.Set("components", []string{})
+
+	result := runSweepTarget(tgt, "", "", v)
+	if result.Context != "test" {
+		t.Fatalf("Got context %s expected test", result.Context)
+	}
+	if result.KubeVersion == nil || result.KubeVersion.Client != "1.7" {
+		t.Fatalf("Got %+v expected client version 1.7", result.KubeVersion)
+	}
+	if result.Error != "" {
+		t.Fatalf("Unexpected error: %s", result.Error)
+	}
+	if result.VersionCheck != "" {
+		t.Fatalf("Unexpected version check mismatch: %s", result.VersionCheck)
+	}
+}
+
+func TestRunSweepTargetVersionMismatch(t *testing.T) {
+	kubeoutput := []byte(`Client Version: version.Info{Major:"1", Minor:"7"}
+Server Version: version.Info{Major:"1", Minor:"7"}`)
+
+	tgt := target{Context: "test", Remote: fakeRemote{out: map[string][]byte{"kubectl": kubeoutput, "ps": []byte("")}}}
+	v := viper.New()
+	v.Set("components", []string{})
+
+	result := runSweepTarget(tgt, "1", "8", v)
+	if result.VersionCheck == "" {
+		t.Fatalf("Expected a version check mismatch")
+	}
+}
+
+func TestRunSweep(t *testing.T) {
+	targets := []target{
+		{Context: "a", Remote: fakeRemote{err: errors.New("boom")}},
+	}
+	v := viper.New()
+	v.Set("components", []string{})
+
+	results := runSweep(targets, 2, "", "", v)
+	if len(results) != 1 {
+		t.Fatalf("Got %d results expected 1", len(results))
+	}
+	if results[0].Error == "" {
+		t.Fatalf("Expected an error result")
+	}
+}
+
+func TestSweepJUnitSuite(t *testing.T) {
+	results := []sweepResult{
+		{Context: "good"},
+		{Context: "bad", Error: "something went wrong"},
+		{Context: "mismatched", VersionCheck: "Unexpected Server version 1.7"},
+	}
+
+	suite := sweepJUnitSuite(results)
+	if suite.Tests != 3 || suite.Failures != 2 {
+		t.Fatalf("Got tests=%d failures=%d expected tests=3 failures=2", suite.Tests, suite.Failures)
+	}
+}
@@ -0,0 +1,542 @@
+// Copyright © 2017 Aqua Security Software Ltd. <info@aquasec.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	regexVersionMajor = regexp.MustCompile(`Major:"(\d+)"`)
+	regexVersionMinor = regexp.MustCompile(`Minor:"(\d+)"`)
+)
+
+// psFunc returns a process listing to search for a running binary in, on
+// the current host. It is a package variable so tests can stub it out
+// (see fakeps in util_test.go); psSource calls it for targets with no
+// Remote transport of their own.
+var psFunc = ps
+
+// statFunc checks for the existence of a file on the current host. It is
+// a package variable so tests can stub it out (see fakestat in
+// util_test.go); statSource calls it for targets with no Remote
+// transport of their own.
+var statFunc = os.Stat
+
+// version holds the major.minor version reported by kubectl for the
+// client and the server it talked to.
+type version struct {
+	Client string `json:"client"`
+	Server string `json:"server"`
+}
+
+// execOutputFunc runs a command and returns its output. It is a package
+// variable so tests can stub out the package-manager binaryLocators the
+// same way psFunc is stubbed out for ps.
+var execOutputFunc = func(name string, args ...string) ([]byte, error) {
+	return localExec{}.Output(name, args...)
+}
+
+// binaryInfo describes a located binary: where it is, and, if it was
+// resolved via a distro package manager, the package that owns it.
+type binaryInfo struct {
+	Path    string `json:"path"`
+	Pkg     string `json:"pkg,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// binaryLocator finds the binary backing one of a component's candidate
+// names on tgt's node.
+type binaryLocator interface {
+	locate(tgt target, candidates []string) (binaryInfo, error)
+}
+
+// binaryLocators are tried in order until one of them finds a match;
+// this lets getBinaries fall back to a distro package manager when the
+// component's process isn't visible to ps, e.g. in a sidecar without
+// hostPID or when scanning a static-pod node offline.
+var binaryLocators = []binaryLocator{psLocator{}, dpkgLocator{}, rpmLocator{}}
+
+// psLocator finds a binary among the node's running processes.
+type psLocator struct{}
+
+func (psLocator) locate(tgt target, candidates []string) (binaryInfo, error) {
+	bin, err := findExecutable(tgt, candidates)
+	if err != nil {
+		return binaryInfo{}, err
+	}
+	return binaryInfo{Path: bin}, nil
+}
+
+// dpkgLocator finds a binary by asking dpkg which installed file matches
+// one of the candidate names, then looks up the owning package's version
+// with dpkg-query.
+type dpkgLocator struct{}
+
+func (dpkgLocator) locate(tgt target, candidates []string) (binaryInfo, error) {
+	for _, c := range candidates {
+		out, err := execSource(tgt, "dpkg", "-S", c)
+		if err != nil {
+			continue
+		}
+
+		pkg, path, ok := parseDpkgS(string(out))
+		if !ok {
+			continue
+		}
+
+		return binaryInfo{Path: path, Pkg: pkg, Version: dpkgVersion(tgt, pkg)}, nil
+	}
+
+	return binaryInfo{}, fmt.Errorf("failed to find any of the candidates %q via dpkg", candidates)
+}
+
+// parseDpkgS parses the first line of `dpkg -S`'s output, "pkg: /path". A
+// diagnostic line such as "dpkg-query: no path found matching pattern
+// thing" also splits on ": " into two parts, but its second part isn't a
+// path, so it's rejected here rather than mistaken for a match.
+func parseDpkgS(out string) (pkg string, path string, ok bool) {
+	line := strings.SplitN(strings.TrimSpace(out), "\n", 2)[0]
+	parts := strings.SplitN(line, ": ", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[1], "/") {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// dpkgVersion returns the installed version of pkg on tgt's node, or ""
+// if it can't be determined.
+func dpkgVersion(tgt target, pkg string) string {
+	out, err := execSource(tgt, "dpkg-query", "-W", "-f=${Version}", pkg)
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+// rpmLocator finds a binary by asking rpm which installed package
+// provides one of the candidate names, then resolving that package's
+// file list to recover the binary's path.
+type rpmLocator struct{}
+
+func (rpmLocator) locate(tgt target, candidates []string) (binaryInfo, error) {
+	for _, c := range candidates {
+		out, err := execSource(tgt, "rpm", "-q", "--whatprovides", c, "--qf", "%{NAME} %{VERSION}\n")
+		if err != nil {
+			continue
+		}
+
+		fields := strings.Fields(strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0])
+		if len(fields) != 2 {
+			continue
+		}
+		pkg, ver := fields[0], fields[1]
+
+		path, err := rpmBinaryPath(tgt, pkg, c)
+		if err != nil {
+			continue
+		}
+
+		return binaryInfo{Path: path, Pkg: pkg, Version: ver}, nil
+	}
+
+	return binaryInfo{}, fmt.Errorf("failed to find any of the candidates %q via rpm", candidates)
+}
+
+// rpmBinaryPath returns the path rpm -ql reports for pkg whose basename
+// matches candidate, on tgt's node.
+func rpmBinaryPath(tgt target, pkg string, candidate string) (string, error) {
+	out, err := execSource(tgt, "rpm", "-ql", pkg)
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if filepath.Base(line) == candidate {
+			return line, nil
+		}
+	}
+
+	return "", fmt.Errorf("package %s does not own a file named %s", pkg, candidate)
+}
+
+// locateBinary tries each of binaryLocators in turn against tgt's node,
+// returning the first match, or the last locator's error if none of them
+// found candidates.
+func locateBinary(tgt target, candidates []string) (binaryInfo, error) {
+	var err error
+	for _, l := range binaryLocators {
+		var bin binaryInfo
+		if bin, err = l.locate(tgt, candidates); err == nil {
+			return bin, nil
+		}
+	}
+	return binaryInfo{}, err
+}
+
+// execSource runs name against tgt's node: its Remote transport if set,
+// or the default (test-stubbable) execOutputFunc otherwise. This is what
+// lets the dpkg/rpm binaryLocators honor tgt.Remote the same way
+// psLocator already does via psSource, instead of always running
+// against the host kube-bench itself is on.
+func execSource(tgt target, name string, args ...string) ([]byte, error) {
+	if tgt.Remote != nil {
+		return tgt.Remote.Output(name, args...)
+	}
+	return execOutputFunc(name, args...)
+}
+
+// remoteExec runs a command against a target's node. Implementations let
+// the check pipeline reach a node other than the one kube-bench is
+// running on, e.g. over SSH or `docker exec` into a container.
+type remoteExec interface {
+	Output(name string, args ...string) ([]byte, error)
+}
+
+// localExec runs commands on the current host via os/exec.
+type localExec struct{}
+
+func (localExec) Output(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
+
+// target identifies the cluster a check pipeline run is scoped to: a
+// kubeconfig context, and, for checks that shell out to the node itself
+// (ps, package managers), the transport used to reach that node.
+type target struct {
+	// Context is the kubeconfig context to use, or "" for kubectl's
+	// current context.
+	Context string
+	// Remote reaches the target's node for local checks. A nil Remote
+	// means the current host is examined directly.
+	Remote remoteExec
+}
+
+// localTarget is the zero-value target: the current kubeconfig context,
+// examined on the current host.
+var localTarget = target{}
+
+func (t target) remote() remoteExec {
+	if t.Remote != nil {
+		return t.Remote
+	}
+	return localExec{}
+}
+
+// kubectlArgs prepends the --context flag for t, if any, to args.
+func (t target) kubectlArgs(args ...string) []string {
+	if t.Context != "" {
+		return append([]string{"--context", t.Context}, args...)
+	}
+	return args
+}
+
+// kubeVersionOutput runs kubectl version against tgt and returns its raw
+// output.
+func kubeVersionOutput(tgt target) (string, error) {
+	out, err := tgt.remote().Output("kubectl", tgt.kubectlArgs("version")...)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// checkKubeVersion runs kubectl version against tgt, returning the
+// resolved client/server versions (or nil if kubectl could not be run)
+// and, if expMajor and expMinor are both set, any mismatch message from
+// checking the reported server version against expMajor.expMinor via
+// checkVersion.
+func checkKubeVersion(tgt target, expMajor string, expMinor string) (*version, string) {
+	s, err := kubeVersionOutput(tgt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error executing kubectl version for context %q: %v\n", tgt.Context, err)
+		return nil, ""
+	}
+
+	ver := &version{
+		Client: getVersion("Client", s),
+		Server: getVersion("Server", s),
+	}
+
+	if expMajor == "" || expMinor == "" {
+		return ver, ""
+	}
+
+	return ver, checkVersion("Server", s, expMajor, expMinor)
+}
+
+// getKubeVersion runs kubectl version against tgt and returns the
+// client/server versions it reported, or nil if kubectl could not be
+// run.
+func getKubeVersion(tgt target) *version {
+	ver, _ := checkKubeVersion(tgt, "", "")
+	return ver
+}
+
+// getVersion returns the "major.minor" version reported for t ("Client"
+// or "Server") in the kubectl version output s, or "" if it isn't
+// present.
+func getVersion(t string, s string) string {
+	reGetVersion := regexp.MustCompile(t + ` Version: version\.Info{[^}]*}`)
+	ss := reGetVersion.FindString(s)
+	if ss == "" {
+		return ""
+	}
+
+	major := versionMatch(regexVersionMajor, ss)
+	minor := versionMatch(regexVersionMinor, ss)
+	if major == "" || minor == "" {
+		return ""
+	}
+
+	return major + "." + minor
+}
+
+// checkVersion checks that the t ("Client" or "Server") version reported
+// in the kubectl version output s matches expMajor.expMinor, returning a
+// message describing the mismatch, or "" if it matches.
+func checkVersion(t string, s string, expMajor string, expMinor string) string {
+	v := getVersion(t, s)
+	if v == "" {
+		return fmt.Sprintf("Couldn't find %s version from kubectl output '%s'", t, s)
+	}
+
+	parts := strings.SplitN(v, ".", 2)
+	if parts[0] != expMajor || parts[1] != expMinor {
+		return fmt.Sprintf("Unexpected %s version %s", t, v)
+	}
+
+	return ""
+}
+
+// versionMatch returns the first capture group of r's match in s, or ""
+// if r doesn't match.
+func versionMatch(r *regexp.Regexp, s string) string {
+	match := r.FindStringSubmatch(s)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// ps lists the processes running on the current host. proc is unused; it
+// exists so psFunc can be swapped for a filtered implementation without
+// changing call sites.
+func ps(proc string) string {
+	out, err := localExec{}.Output("ps", "-e", "-o", "cmd")
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+// psSource returns the process listing to search for a running binary in,
+// on tgt's node: its Remote transport if set, or the default
+// (test-stubbable) psFunc otherwise. tgt is threaded through explicitly
+// rather than repointing psFunc itself, so concurrent lookups against
+// different targets don't race on shared package state.
+func psSource(tgt target, proc string) string {
+	if tgt.Remote != nil {
+		out, err := tgt.Remote.Output("ps", "-e", "-o", "cmd")
+		if err != nil {
+			return ""
+		}
+		return string(out)
+	}
+	return psFunc(proc)
+}
+
+// verifyBin reports whether bin appears to be running on tgt's node,
+// according to psSource's output.
+func verifyBin(tgt target, bin string) bool {
+	out := psSource(tgt, bin)
+
+	for _, line := range strings.Split(out, "\n") {
+		if matchBinaryCmdline(bin, line) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchBinaryCmdline reports whether line's executable and leading
+// arguments match bin word-for-word, allowing each word in line to be a
+// longer form of the corresponding word in bin (e.g. "param" matches
+// "param1"). The executable is matched on basename only, so a path such
+// as "/usr/bin/cmd" is recognised as "cmd".
+func matchBinaryCmdline(bin string, line string) bool {
+	binWords := strings.Fields(bin)
+	lineWords := strings.Fields(line)
+
+	if len(binWords) == 0 || len(lineWords) < len(binWords) {
+		return false
+	}
+
+	lineWords[0] = filepath.Base(lineWords[0])
+
+	for i, w := range binWords {
+		if !strings.HasPrefix(lineWords[i], w) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// findExecutable returns the first of candidates that appears to be
+// running on tgt's node, according to verifyBin.
+func findExecutable(tgt target, candidates []string) (string, error) {
+	for _, c := range candidates {
+		if verifyBin(tgt, c) {
+			return c, nil
+		}
+	}
+
+	return "", fmt.Errorf("failed to find any of the candidates %q in the process list", candidates)
+}
+
+// getBinaries finds the binary for each of the configured components on
+// tgt's node, returning a map of component to resolved binary. Each
+// component's candidate binary names are first searched for among
+// running processes, falling back to the node's package manager (dpkg,
+// then rpm) when the process isn't visible.
+func getBinaries(tgt target, v *viper.Viper) map[string]binaryInfo {
+	info := make(map[string]binaryInfo)
+
+	for _, component := range toStringSlice(v.Get("components")) {
+		conf := componentConf(v, component)
+		if conf == nil {
+			continue
+		}
+
+		optional, _ := conf["optional"].(bool)
+		bin, err := locateBinary(tgt, toStringSlice(conf["bins"]))
+		if err != nil {
+			if !optional {
+				fmt.Fprintf(os.Stderr, "Missing binary for component %s: %v\n", component, err)
+			}
+			bin = binaryInfo{Path: component}
+		}
+
+		info[component] = bin
+	}
+
+	return info
+}
+
+// statSource checks for the existence of file on tgt's node: its Remote
+// transport if set, or the default (test-stubbable) statFunc otherwise.
+// tgt is threaded through explicitly rather than repointing statFunc
+// itself, so concurrent lookups against different targets don't race on
+// shared package state.
+func statSource(tgt target, file string) (os.FileInfo, error) {
+	if tgt.Remote != nil {
+		_, err := tgt.Remote.Output("test", "-e", file)
+		return nil, err
+	}
+	return statFunc(file)
+}
+
+// findConfigFile returns the first of candidates that exists on tgt's
+// node, according to statSource, or "" if none of them do.
+func findConfigFile(tgt target, candidates []string) string {
+	for _, c := range candidates {
+		if _, err := statSource(tgt, c); err == nil {
+			return c
+		}
+	}
+	return ""
+}
+
+// getConfigFiles finds the config file for each of the configured
+// components on tgt's node, returning a map of component to resolved
+// path.
+func getConfigFiles(tgt target, v *viper.Viper) map[string]string {
+	conf := make(map[string]string)
+
+	for _, component := range toStringSlice(v.Get("components")) {
+		c := componentConf(v, component)
+		if c == nil {
+			continue
+		}
+
+		file := findConfigFile(tgt, toStringSlice(c["confs"]))
+		if file == "" {
+			file, _ = c["defaultconf"].(string)
+			if file == "" {
+				file = component
+			}
+		}
+
+		conf[component] = file
+	}
+
+	return conf
+}
+
+// componentConf returns the config map for component, or nil if it isn't
+// defined.
+func componentConf(v *viper.Viper, component string) map[string]interface{} {
+	c, _ := v.Get(component).(map[string]interface{})
+	return c
+}
+
+// toStringSlice converts the []string or []interface{} viper hands back
+// for a config list into a []string.
+func toStringSlice(i interface{}) []string {
+	switch t := i.(type) {
+	case []string:
+		return t
+	case []interface{}:
+		out := make([]string, len(t))
+		for idx, v := range t {
+			out[idx] = fmt.Sprintf("%v", v)
+		}
+		return out
+	}
+	return nil
+}
+
+// multiWordReplace replaces every (possibly multi-word) occurrence of
+// subname in s with sub, quoting sub if it contains more than one word.
+func multiWordReplace(s string, subname string, sub string) string {
+	f := strings.Fields(sub)
+	if len(f) > 1 {
+		sub = "'" + sub + "'"
+	}
+
+	re := regexp.MustCompile(`\b` + regexp.QuoteMeta(subname) + `\b`)
+	return re.ReplaceAllString(s, sub)
+}
+
+// makeSubstitutions replaces each "$<key><ext>" placeholder in s with
+// subst[key], for every non-empty value in subst.
+func makeSubstitutions(s string, ext string, subst map[string]string) string {
+	for k, v := range subst {
+		if v == "" {
+			continue
+		}
+		s = strings.Replace(s, "$"+k+ext, v, -1)
+	}
+	return s
+}
@@ -15,6 +15,8 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"reflect"
 	"regexp"
@@ -119,7 +121,7 @@ func TestVerifyBin(t *testing.T) {
 	for id, c := range cases {
 		t.Run(strconv.Itoa(id), func(t *testing.T) {
 			g = c.psOut
-			v := verifyBin(c.proc)
+			v := verifyBin(localTarget, c.proc)
 			if v != c.exp {
 				t.Fatalf("Expected %v got %v", c.exp, v)
 			}
@@ -147,7 +149,7 @@ func TestFindExecutable(t *testing.T) {
 	for id, c := range cases {
 		t.Run(strconv.Itoa(id), func(t *testing.T) {
 			g = c.psOut
-			e, err := findExecutable(c.candidates)
+			e, err := findExecutable(localTarget, c.candidates)
 			if e != c.exp {
 				t.Fatalf("Expected %v got %v", c.exp, e)
 			}
@@ -163,53 +165,146 @@ func TestFindExecutable(t *testing.T) {
 	}
 }
 
+func fakeExecOutput(name string, args ...string) ([]byte, error) {
+	switch name {
+	case "dpkg":
+		if dpkgSErr != nil {
+			return nil, dpkgSErr
+		}
+		return []byte(dpkgSOut), nil
+	case "dpkg-query":
+		if dpkgQueryErr != nil {
+			return nil, dpkgQueryErr
+		}
+		return []byte(dpkgQueryOut), nil
+	case "rpm":
+		if len(args) > 0 && args[0] == "-ql" {
+			if rpmQlErr != nil {
+				return nil, rpmQlErr
+			}
+			return []byte(rpmQlOut), nil
+		}
+		if rpmQErr != nil {
+			return nil, rpmQErr
+		}
+		return []byte(rpmQOut), nil
+	default:
+		return nil, fmt.Errorf("fakeExecOutput: unexpected command %s", name)
+	}
+}
+
+var (
+	dpkgSOut     string
+	dpkgSErr     error
+	dpkgQueryOut string
+	dpkgQueryErr error
+
+	rpmQOut  string
+	rpmQErr  error
+	rpmQlOut string
+	rpmQlErr error
+)
+
 func TestGetBinaries(t *testing.T) {
 	cases := []struct {
-		config map[string]interface{}
-		psOut  string
-		exp    map[string]string
+		config   map[string]interface{}
+		psOut    string
+		dpkgOut  string
+		rpmQOut  string
+		rpmQlOut string
+		exp      map[string]binaryInfo
 	}{
 		{
 			config: map[string]interface{}{"components": []string{"apiserver"}, "apiserver": map[string]interface{}{"bins": []string{"apiserver", "kube-apiserver"}}},
 			psOut:  "kube-apiserver",
-			exp:    map[string]string{"apiserver": "kube-apiserver"},
+			exp:    map[string]binaryInfo{"apiserver": {Path: "kube-apiserver"}},
 		},
 		{
 			// "thing" is not in the list of components
 			config: map[string]interface{}{"components": []string{"apiserver"}, "apiserver": map[string]interface{}{"bins": []string{"apiserver", "kube-apiserver"}}, "thing": map[string]interface{}{"bins": []string{"something else", "thing"}}},
 			psOut:  "kube-apiserver thing",
-			exp:    map[string]string{"apiserver": "kube-apiserver"},
+			exp:    map[string]binaryInfo{"apiserver": {Path: "kube-apiserver"}},
 		},
 		{
 			// "anotherthing" in list of components but doesn't have a defintion
 			config: map[string]interface{}{"components": []string{"apiserver", "anotherthing"}, "apiserver": map[string]interface{}{"bins": []string{"apiserver", "kube-apiserver"}}, "thing": map[string]interface{}{"bins": []string{"something else", "thing"}}},
 			psOut:  "kube-apiserver thing",
-			exp:    map[string]string{"apiserver": "kube-apiserver"},
+			exp:    map[string]binaryInfo{"apiserver": {Path: "kube-apiserver"}},
 		},
 		{
 			// more than one component
 			config: map[string]interface{}{"components": []string{"apiserver", "thing"}, "apiserver": map[string]interface{}{"bins": []string{"apiserver", "kube-apiserver"}}, "thing": map[string]interface{}{"bins": []string{"something else", "thing"}}},
 			psOut:  "kube-apiserver \nthing",
-			exp:    map[string]string{"apiserver": "kube-apiserver", "thing": "thing"},
+			exp:    map[string]binaryInfo{"apiserver": {Path: "kube-apiserver"}, "thing": {Path: "thing"}},
 		},
 		{
-			// default binary to component name
+			// default binary to component name once ps and the package
+			// managers all fail to find a match
 			config: map[string]interface{}{"components": []string{"apiserver", "thing"}, "apiserver": map[string]interface{}{"bins": []string{"apiserver", "kube-apiserver"}}, "thing": map[string]interface{}{"bins": []string{"something else", "thing"}, "optional": true}},
 			psOut:  "kube-apiserver \notherthing some params",
-			exp:    map[string]string{"apiserver": "kube-apiserver", "thing": "thing"},
+			exp:    map[string]binaryInfo{"apiserver": {Path: "kube-apiserver"}, "thing": {Path: "thing"}},
+		},
+		{
+			// falls back to dpkg when the process isn't visible to ps
+			config:  map[string]interface{}{"components": []string{"thing"}, "thing": map[string]interface{}{"bins": []string{"thing"}}},
+			psOut:   "",
+			dpkgOut: "thing-pkg: /usr/bin/thing\n",
+			exp:     map[string]binaryInfo{"thing": {Path: "/usr/bin/thing", Pkg: "thing-pkg"}},
+		},
+		{
+			// dpkg miss falls through to rpm
+			config:   map[string]interface{}{"components": []string{"thing"}, "thing": map[string]interface{}{"bins": []string{"thing"}}},
+			psOut:    "",
+			rpmQOut:  "thing-pkg 1.2.3\n",
+			rpmQlOut: "/usr/bin/otherfile\n/usr/bin/thing\n",
+			exp:      map[string]binaryInfo{"thing": {Path: "/usr/bin/thing", Pkg: "thing-pkg", Version: "1.2.3"}},
+		},
+		{
+			// neither ps, dpkg, nor rpm find a match: default to the
+			// component name
+			config: map[string]interface{}{"components": []string{"thing"}, "thing": map[string]interface{}{"bins": []string{"thing"}, "optional": true}},
+			psOut:  "",
+			exp:    map[string]binaryInfo{"thing": {Path: "thing"}},
+		},
+		{
+			// rpm -q succeeds but its --qf output is malformed: treated
+			// the same as not finding a match
+			config:  map[string]interface{}{"components": []string{"thing"}, "thing": map[string]interface{}{"bins": []string{"thing"}, "optional": true}},
+			psOut:   "",
+			rpmQOut: "not-the-expected-format\n",
+			exp:     map[string]binaryInfo{"thing": {Path: "thing"}},
 		},
 	}
 
 	v := viper.New()
 	psFunc = fakeps
+	execOutputFunc = fakeExecOutput
 
 	for id, c := range cases {
 		t.Run(strconv.Itoa(id), func(t *testing.T) {
 			g = c.psOut
+			dpkgSOut = c.dpkgOut
+			if c.dpkgOut == "" {
+				dpkgSErr = errors.New("no dpkg match")
+			} else {
+				dpkgSErr = nil
+			}
+			rpmQOut = c.rpmQOut
+			if c.rpmQOut == "" {
+				rpmQErr = errors.New("no rpm match")
+			} else {
+				rpmQErr = nil
+			}
+			rpmQlOut = c.rpmQlOut
+			if c.rpmQlOut == "" {
+				rpmQlErr = errors.New("no rpm -ql output")
+			} else {
+				rpmQlErr = nil
+			}
 			for k, val := range c.config {
 				v.Set(k, val)
 			}
-			m := getBinaries(v)
+			m := getBinaries(localTarget, v)
 			if !reflect.DeepEqual(m, c.exp) {
 				t.Fatalf("Got %v\nExpected %v", m, c.exp)
 			}
@@ -217,6 +312,86 @@ func TestGetBinaries(t *testing.T) {
 	}
 }
 
+func TestParseDpkgS(t *testing.T) {
+	cases := []struct {
+		out     string
+		expPkg  string
+		expPath string
+		expOk   bool
+	}{
+		{out: "thing-pkg: /usr/bin/thing\n", expPkg: "thing-pkg", expPath: "/usr/bin/thing", expOk: true},
+		{out: "thing-pkg: /usr/bin/thing\nother: /usr/bin/other\n", expPkg: "thing-pkg", expPath: "/usr/bin/thing", expOk: true},
+		{out: "dpkg-query: no path found matching pattern thing", expOk: false},
+		{out: "", expOk: false},
+	}
+
+	for id, c := range cases {
+		t.Run(strconv.Itoa(id), func(t *testing.T) {
+			pkg, path, ok := parseDpkgS(c.out)
+			if ok != c.expOk {
+				t.Fatalf("Got ok=%v expected %v", ok, c.expOk)
+			}
+			if ok && (pkg != c.expPkg || path != c.expPath) {
+				t.Fatalf("Got pkg=%q path=%q expected pkg=%q path=%q", pkg, path, c.expPkg, c.expPath)
+			}
+		})
+	}
+}
+
+func TestDpkgVersion(t *testing.T) {
+	cases := []struct {
+		queryOut string
+		queryErr error
+		exp      string
+	}{
+		{queryOut: "1.2.3-1ubuntu1", exp: "1.2.3-1ubuntu1"},
+		{queryErr: errors.New("dpkg-query: package not installed"), exp: ""},
+	}
+
+	execOutputFunc = fakeExecOutput
+
+	for id, c := range cases {
+		t.Run(strconv.Itoa(id), func(t *testing.T) {
+			dpkgQueryOut = c.queryOut
+			dpkgQueryErr = c.queryErr
+			v := dpkgVersion(localTarget, "thing-pkg")
+			if v != c.exp {
+				t.Fatalf("Got %q expected %q", v, c.exp)
+			}
+		})
+	}
+}
+
+func TestRpmBinaryPath(t *testing.T) {
+	cases := []struct {
+		qlOut     string
+		qlErr     error
+		candidate string
+		exp       string
+		expErr    bool
+	}{
+		{qlOut: "/usr/bin/otherfile\n/usr/bin/thing\n", candidate: "thing", exp: "/usr/bin/thing"},
+		{qlOut: "/usr/bin/otherfile\n", candidate: "thing", expErr: true},
+		{qlErr: errors.New("rpm: package thing-pkg is not installed"), candidate: "thing", expErr: true},
+	}
+
+	execOutputFunc = fakeExecOutput
+
+	for id, c := range cases {
+		t.Run(strconv.Itoa(id), func(t *testing.T) {
+			rpmQlOut = c.qlOut
+			rpmQlErr = c.qlErr
+			path, err := rpmBinaryPath(localTarget, "thing-pkg", c.candidate)
+			if (err != nil) != c.expErr {
+				t.Fatalf("Got err=%v expected error=%v", err, c.expErr)
+			}
+			if err == nil && path != c.exp {
+				t.Fatalf("Got %q expected %q", path, c.exp)
+			}
+		})
+	}
+}
+
 func TestMultiWordReplace(t *testing.T) {
 	cases := []struct {
 		input   string
@@ -240,7 +415,7 @@ func TestMultiWordReplace(t *testing.T) {
 }
 
 func TestGetKubeVersion(t *testing.T) {
-	ver := getKubeVersion()
+	ver := getKubeVersion(localTarget)
 	if ver == nil {
 		t.Log("Expected non nil version info.")
 	} else {
@@ -271,7 +446,7 @@ func TestFindConfigFile(t *testing.T) {
 		t.Run(strconv.Itoa(id), func(t *testing.T) {
 			e = c.statResults
 			eIndex = 0
-			conf := findConfigFile(c.input)
+			conf := findConfigFile(localTarget, c.input)
 			if conf != c.exp {
 				t.Fatalf("Got %s expected %s", conf, c.exp)
 			}
@@ -339,7 +514,7 @@ func TestGetConfigFiles(t *testing.T) {
 			e = c.statResults
 			eIndex = 0
 
-			m := getConfigFiles(v)
+			m := getConfigFiles(localTarget, v)
 			if !reflect.DeepEqual(m, c.exp) {
 				t.Fatalf("Got %v\nExpected %v", m, c.exp)
 			}